@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is stored under the "user/<email>" key and indexed for token lookup
+// under "token/<token>" -> email.
+type User struct {
+	ID           string `json:"id"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"password_hash"`
+	Token        string `json:"token"`
+	Admin        bool   `json:"admin"`
+}
+
+var (
+	errUserExists      = errors.New("user already exists")
+	errInvalidLogin    = errors.New("invalid email or password")
+	errMissingEmail    = errors.New("email is required")
+	errMissingPassword = errors.New("password is required")
+	errInvalidEmail    = errors.New("invalid email address")
+)
+
+type authedUserKey struct{}
+
+func userFromContext(r *http.Request) (*User, bool) {
+	u, ok := r.Context().Value(authedUserKey{}).(*User)
+	return u, ok
+}
+
+func userKey(email string) string  { return "user/" + email }
+func tokenKey(token string) string { return "token/" + token }
+
+func generateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// validateEmail rejects anything that isn't a single, bare email address.
+// The result becomes a segment of a storage key ("u/<id>/..."), so this is
+// also what keeps one user's ID from ever extending another's key prefix.
+func validateEmail(email string) error {
+	if strings.ContainsAny(email, "/\\") {
+		return errInvalidEmail
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errInvalidEmail
+	}
+	return nil
+}
+
+func (s *Server) registerUser(email, password string) (*User, error) {
+	if email == "" {
+		return nil, errMissingEmail
+	}
+	if password == "" {
+		return nil, errMissingPassword
+	}
+	if err := validateEmail(email); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		ID:           email,
+		Email:        email,
+		PasswordHash: string(hash),
+		Token:        token,
+	}
+
+	err = s.db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte(userKey(email))); err == nil {
+			return errUserExists
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		// The very first account registered becomes an admin, so there's
+		// always at least one token that can list/delete across users.
+		user.Admin = !anyUserExists(txn)
+
+		data, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		if err := txn.Set([]byte(userKey(email)), data); err != nil {
+			return err
+		}
+		return txn.Set([]byte(tokenKey(token)), []byte(email))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// anyUserExists reports whether at least one account has already been
+// registered, so registerUser can tell the bootstrap admin apart from
+// everyone else.
+func anyUserExists(txn *badger.Txn) bool {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte("user/")
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	it.Rewind()
+	return it.ValidForPrefix([]byte("user/"))
+}
+
+func (s *Server) loginUser(email, password string) (*User, error) {
+	user, err := s.getUserByEmail(email)
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return nil, errInvalidLogin
+		}
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, errInvalidLogin
+	}
+
+	return user, nil
+}
+
+func (s *Server) getUserByEmail(email string) (*User, error) {
+	var user User
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(userKey(email)))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &user)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *Server) getUserByToken(token string) (*User, error) {
+	var email string
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(tokenKey(token)))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			email = string(val)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.getUserByEmail(email)
+}
+
+func (s *Server) handleRegister(c *Context) *HTTPError {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(c.R.Body).Decode(&req); err != nil {
+		return newHTTPError(http.StatusBadRequest, "Invalid JSON")
+	}
+
+	user, err := s.registerUser(req.Email, req.Password)
+	if err != nil {
+		switch err {
+		case errMissingEmail, errMissingPassword, errInvalidEmail:
+			return newHTTPError(http.StatusBadRequest, err.Error())
+		case errUserExists:
+			return newHTTPError(http.StatusConflict, err.Error())
+		default:
+			return wrapHTTPError(http.StatusInternalServerError, "Failed to register user", err)
+		}
+	}
+
+	c.SendJSON(http.StatusOK, map[string]string{
+		"token": user.Token,
+		"email": user.Email,
+	})
+	return nil
+}
+
+func (s *Server) handleLogin(c *Context) *HTTPError {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(c.R.Body).Decode(&req); err != nil {
+		return newHTTPError(http.StatusBadRequest, "Invalid JSON")
+	}
+
+	user, err := s.loginUser(req.Email, req.Password)
+	if err != nil {
+		if err == errInvalidLogin {
+			return newHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		return wrapHTTPError(http.StatusInternalServerError, "Failed to log in", err)
+	}
+
+	c.SendJSON(http.StatusOK, map[string]string{
+		"token": user.Token,
+		"email": user.Email,
+	})
+	return nil
+}
+
+// withAuth resolves the bearer token (if any) to a user and stores it on the
+// request context before delegating to next. A missing or invalid token is
+// not an error here; handlePath rejects unauthenticated requests itself so
+// that public-path lookups can still go through unauthenticated.
+func (s *Server) withAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok || token == "" {
+			next(w, r)
+			return
+		}
+
+		user, err := s.getUserByToken(token)
+		if err != nil {
+			if err != badger.ErrKeyNotFound {
+				(&Context{W: w, R: r}).SendError(wrapHTTPError(http.StatusInternalServerError, "Database error", err))
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authedUserKey{}, user)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// namespacedKey rewrites a request path into a user's private keyspace. An
+// admin may reach into another user's namespace by passing it explicitly via
+// the "u/<userid>/..." form, so admins are handed the raw path unchanged.
+func namespacedKey(user *User, path string) string {
+	if user.Admin {
+		return path
+	}
+	return "u/" + user.ID + "/" + path
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// newTestServer opens a throwaway BadgerDB under t.TempDir so each test gets
+// an isolated store that's cleaned up automatically.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	opts := badger.DefaultOptions(t.TempDir())
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &Server{db: db, blobThreshold: defaultBlobThreshold}
+}
+
+func doRequest(s *Server, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.newRouter().ServeHTTP(rec, req)
+	return rec
+}
+
+func registerUser(t *testing.T, s *Server, email, password string) string {
+	t.Helper()
+	rec := doRequest(s, "POST", "/_auth/register", "", `{"email":"`+email+`","password":"`+password+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("register %s: got %d: %s", email, rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("register %s: bad response JSON: %v", email, err)
+	}
+	return resp.Token
+}
+
+// TestRegisterRejectsSlashInEmail guards against a user ID that could extend
+// another tenant's "u/<id>/" key prefix, e.g. registering
+// "victim@example.com/subpath" to reach into victim@example.com's namespace.
+func TestRegisterRejectsSlashInEmail(t *testing.T) {
+	s := newTestServer(t)
+	registerUser(t, s, "victim@example.com", "pw")
+
+	rec := doRequest(s, "POST", "/_auth/register", "", `{"email":"victim@example.com/subpath","password":"pw"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for email containing '/', got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestUserIsolation verifies that one user's data is invisible to another
+// user, both through direct path GETs and through /_list.
+func TestUserIsolation(t *testing.T) {
+	s := newTestServer(t)
+	tokenA := registerUser(t, s, "alice@example.com", "pw")
+	tokenB := registerUser(t, s, "bob@example.com", "pw")
+
+	put := doRequest(s, "POST", "/secret", tokenA, `{"content":"ALICESECRET","content_type":"text/plain"}`)
+	if put.Code != http.StatusOK {
+		t.Fatalf("store as alice: got %d: %s", put.Code, put.Body.String())
+	}
+
+	getAsB := doRequest(s, "GET", "/secret", tokenB, "")
+	if getAsB.Code != http.StatusNotFound {
+		t.Fatalf("bob should not see alice's path, got %d: %s", getAsB.Code, getAsB.Body.String())
+	}
+
+	listAsB := doRequest(s, "GET", "/_list/", tokenB, "")
+	if listAsB.Code != http.StatusOK {
+		t.Fatalf("bob's list: got %d: %s", listAsB.Code, listAsB.Body.String())
+	}
+	if strings.Contains(listAsB.Body.String(), "ALICESECRET") || strings.Contains(listAsB.Body.String(), "alice") {
+		t.Fatalf("bob's listing leaked alice's data: %s", listAsB.Body.String())
+	}
+}
+
+// TestAdminCanListAcrossUsers verifies the bootstrap-admin role bit actually
+// unlocks the cross-user listing it's meant to, and that a non-admin can't.
+func TestAdminCanListAcrossUsers(t *testing.T) {
+	s := newTestServer(t)
+	adminToken := registerUser(t, s, "admin@example.com", "pw") // first user registered
+	bobToken := registerUser(t, s, "bob@example.com", "pw")
+
+	if rec := doRequest(s, "POST", "/secret", bobToken, `{"content":"BOBSECRET","content_type":"text/plain"}`); rec.Code != http.StatusOK {
+		t.Fatalf("store as bob: got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	adminList := doRequest(s, "GET", "/_list/u/", adminToken, "")
+	if adminList.Code != http.StatusOK {
+		t.Fatalf("admin list: got %d: %s", adminList.Code, adminList.Body.String())
+	}
+	if !strings.Contains(adminList.Body.String(), "bob@example.com") {
+		t.Fatalf("admin listing should see bob's namespaced path, got: %s", adminList.Body.String())
+	}
+
+	bobList := doRequest(s, "GET", "/_list/u/", bobToken, "")
+	if bobList.Code != http.StatusOK {
+		t.Fatalf("bob list: got %d: %s", bobList.Code, bobList.Body.String())
+	}
+	if strings.TrimSpace(bobList.Body.String()) != "[]" {
+		t.Fatalf("non-admin should not see the cross-user 'u/' prefix, got: %s", bobList.Body.String())
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/gorilla/mux"
+)
+
+// defaultBlobThreshold is the body size above which handlePut stores bytes
+// via CAS and keeps only a reference in the DataValue, rather than inlining
+// them in the record itself.
+const defaultBlobThreshold = 4096
+
+func blobKey(sha string) string { return "blob/" + sha }
+
+// putBlob stores data once under its SHA-256 digest, skipping the write if
+// the blob is already present.
+func (s *Server) putBlob(data []byte) (sha string, err error) {
+	sum := sha256.Sum256(data)
+	sha = hex.EncodeToString(sum[:])
+
+	err = s.db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte(blobKey(sha))); err == nil {
+			return nil
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		return txn.Set([]byte(blobKey(sha)), data)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return sha, nil
+}
+
+func (s *Server) getBlob(sha string) ([]byte, error) {
+	var data []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(blobKey(sha)))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			data = append([]byte(nil), val...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *Server) handleCASPut(c *Context) *HTTPError {
+	if c.User == nil {
+		return newHTTPError(http.StatusUnauthorized, "Authorization: Bearer <token> is required")
+	}
+
+	body, err := io.ReadAll(c.R.Body)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, "Failed to read body")
+	}
+
+	sha, err := s.putBlob(body)
+	if err != nil {
+		return wrapHTTPError(http.StatusInternalServerError, "Failed to store blob", err)
+	}
+
+	c.W.Header().Set("Location", "/_cas/"+sha)
+	c.SendJSON(http.StatusCreated, map[string]any{
+		"sha256": sha,
+		"size":   len(body),
+	})
+	return nil
+}
+
+func (s *Server) handleCASGet(c *Context) *HTTPError {
+	if c.User == nil {
+		return newHTTPError(http.StatusUnauthorized, "Authorization: Bearer <token> is required")
+	}
+	sha := mux.Vars(c.R)["sha"]
+
+	data, err := s.getBlob(sha)
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return newHTTPError(http.StatusNotFound, fmt.Sprintf("Blob '%s' not found", sha))
+		}
+		return wrapHTTPError(http.StatusInternalServerError, "Database error", err)
+	}
+
+	etag := `"` + sha + `"`
+	if c.R.Header.Get("If-None-Match") == etag {
+		c.W.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	c.W.Header().Set("ETag", etag)
+	c.W.Header().Set("Content-Type", "application/octet-stream")
+	c.W.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	c.W.Write(data)
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// HTTPError is the typed result a handler returns instead of writing an
+// error response itself, so that every endpoint's failures end up in the
+// same JSON envelope.
+type HTTPError struct {
+	Code    int
+	Message string
+	Cause   error
+}
+
+func (e *HTTPError) Error() string { return e.Message }
+
+func newHTTPError(code int, message string) *HTTPError {
+	return &HTTPError{Code: code, Message: message}
+}
+
+func wrapHTTPError(code int, message string, cause error) *HTTPError {
+	return &HTTPError{Code: code, Message: message, Cause: cause}
+}
+
+// httpErrorCode maps an HTTP status to the short, machine-readable code used
+// in error envelopes.
+func httpErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "BAD_REQUEST"
+	case http.StatusUnauthorized:
+		return "UNAUTHORIZED"
+	case http.StatusForbidden:
+		return "FORBIDDEN"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusConflict:
+		return "CONFLICT"
+	case http.StatusPreconditionFailed:
+		return "PRECONDITION_FAILED"
+	case http.StatusMethodNotAllowed:
+		return "METHOD_NOT_ALLOWED"
+	default:
+		return "INTERNAL_ERROR"
+	}
+}
+
+// Context bundles the request, response writer, and resolved user that a
+// handler needs, plus helpers for sending uniform JSON responses.
+type Context struct {
+	W    http.ResponseWriter
+	R    *http.Request
+	User *User
+}
+
+// SendJSON writes v as a JSON body with the given status code.
+func (c *Context) SendJSON(status int, v any) {
+	c.W.Header().Set("Content-Type", "application/json")
+	c.W.WriteHeader(status)
+	json.NewEncoder(c.W).Encode(v)
+}
+
+// SendError writes err as a structured JSON error envelope.
+func (c *Context) SendError(err *HTTPError) {
+	if err.Cause != nil {
+		log.Printf("%s %s: %v", c.R.Method, c.R.URL.Path, err.Cause)
+	}
+
+	c.W.Header().Set("Content-Type", "application/json")
+	c.W.WriteHeader(err.Code)
+	json.NewEncoder(c.W).Encode(map[string]any{
+		"error": map[string]string{
+			"code":    httpErrorCode(err.Code),
+			"message": err.Message,
+			"path":    c.R.URL.Path,
+		},
+	})
+}
+
+// dispatch adapts a handler that returns an *HTTPError into a standard
+// http.HandlerFunc: it resolves the authenticated user (if any) onto the
+// Context and turns a returned error into a JSON envelope, so auth, CAS,
+// list, history, and watch endpoints all compose the same way.
+func (s *Server) dispatch(h func(c *Context) *HTTPError) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := userFromContext(r)
+		c := &Context{W: w, R: r, User: user}
+		if err := h(c); err != nil {
+			c.SendError(err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/gorilla/mux"
+)
+
+// defaultListLimit bounds how many entries a single /_list request returns
+// when the caller doesn't specify ?limit=.
+const defaultListLimit = 100
+
+// smallValueInlineLimit is the largest size, in bytes, that ?values=true will
+// inline directly in a listing entry instead of leaving the client to fetch
+// the path separately.
+const smallValueInlineLimit = 256
+
+// handleListGet streams a JSON array of entries under prefix without ever
+// buffering the full result set in memory. Its own internal errors can only
+// be logged, not turned into an error envelope, once streaming has started.
+func (s *Server) handleListGet(c *Context) *HTTPError {
+	prefix := mux.Vars(c.R)["prefix"]
+
+	if c.User == nil {
+		return newHTTPError(http.StatusUnauthorized, "Authorization: Bearer <token> is required")
+	}
+	nsPrefix := namespacedKey(c.User, prefix)
+	strip := ""
+	if !c.User.Admin {
+		strip = "u/" + c.User.ID + "/"
+	}
+
+	limit := defaultListLimit
+	if v := c.R.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	withValues := c.R.URL.Query().Get("values") == "true"
+
+	headPrefix := []byte(headKey(nsPrefix))
+	var afterKey []byte
+	if after := c.R.URL.Query().Get("after"); after != "" {
+		afterKey = []byte(headKey(strip + after))
+	}
+
+	w := c.W
+	w.Header().Set("Content-Type", "application/json")
+
+	io.WriteString(w, "[")
+	enc := json.NewEncoder(w)
+	count := 0
+	first := true
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = headPrefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(headPrefix); it.ValidForPrefix(headPrefix) && count < limit; it.Next() {
+			item := it.Item()
+			headK := item.KeyCopy(nil)
+			if afterKey != nil && bytes.Compare(headK, afterKey) <= 0 {
+				continue
+			}
+			path := strings.TrimPrefix(string(headK), "head/")
+
+			version, err := headVersionTxn(txn, path)
+			if err != nil {
+				return err
+			}
+			vItem, err := txn.Get([]byte(versionKey(path, version)))
+			if err != nil {
+				return err
+			}
+
+			var dv DataValue
+			if err := vItem.Value(func(val []byte) error {
+				return json.Unmarshal(val, &dv)
+			}); err != nil {
+				return err
+			}
+			size := valueSize(txn, dv)
+
+			entry := map[string]any{
+				"path":         strings.TrimPrefix(path, strip),
+				"content_type": dv.ContentType,
+				"size":         size,
+				"version":      version,
+			}
+			if withValues && size <= smallValueInlineLimit {
+				entry["value"] = inlineValue(s, dv)
+			}
+
+			if !first {
+				io.WriteString(w, ",")
+			}
+			first = false
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+
+	io.WriteString(w, "]")
+
+	if err != nil {
+		log.Printf("Failed to list prefix %q: %v", prefix, err)
+	}
+	return nil
+}
+
+// inlineValue returns the best textual representation of a small DataValue
+// for embedding directly in a listing entry.
+func inlineValue(s *Server, dv DataValue) string {
+	if dv.BlobRef != "" {
+		data, err := s.getBlob(dv.BlobRef)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+	if dv.Content != "" {
+		return dv.Content
+	}
+	return string(dv.Data)
+}
+
+// handleListDelete bulk-deletes every entry under prefix. It requires
+// ?confirm=1 since it has no undo.
+func (s *Server) handleListDelete(c *Context) *HTTPError {
+	prefix := mux.Vars(c.R)["prefix"]
+
+	if c.R.URL.Query().Get("confirm") != "1" {
+		return newHTTPError(http.StatusBadRequest, "DELETE requires ?confirm=1")
+	}
+
+	if c.User == nil {
+		return newHTTPError(http.StatusUnauthorized, "Authorization: Bearer <token> is required")
+	}
+	nsPrefix := namespacedKey(c.User, prefix)
+	headPrefix := []byte(headKey(nsPrefix))
+
+	var paths []string
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = headPrefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(headPrefix); it.ValidForPrefix(headPrefix); it.Next() {
+			paths = append(paths, strings.TrimPrefix(string(it.Item().Key()), "head/"))
+		}
+		return nil
+	})
+	if err != nil {
+		return wrapHTTPError(http.StatusInternalServerError, "Database error", err)
+	}
+
+	if len(paths) > 0 {
+		if err := s.deleteValues(paths); err != nil {
+			return wrapHTTPError(http.StatusInternalServerError, "Failed to delete data", err)
+		}
+	}
+
+	c.SendJSON(http.StatusOK, map[string]any{
+		"status":  "success",
+		"deleted": len(paths),
+	})
+	return nil
+}
@@ -6,19 +6,24 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/dgraph-io/badger/v4"
 	"github.com/gorilla/mux"
 )
 
 type Server struct {
-	db *badger.DB
+	db            *badger.DB
+	blobThreshold int
 }
 
 type DataValue struct {
 	Content     string `json:"content"`
 	ContentType string `json:"content_type"`
 	Data        []byte `json:"data,omitempty"`
+	Public      bool   `json:"public,omitempty"`
+	BlobRef     string `json:"blob_ref,omitempty"`
 }
 
 func main() {
@@ -32,26 +37,55 @@ func main() {
 	}
 	defer db.Close()
 
-	server := &Server{db: db}
+	server := &Server{db: db, blobThreshold: defaultBlobThreshold}
 
 	// Initialize with some sample data
 	server.initializeSampleData()
 
-	// Create router
-	r := mux.NewRouter()
-
-	// Handle all paths with wildcard
-	r.HandleFunc("/{path:.*}", server.handlePath)
-
 	// Start server
 	fmt.Println("Server starting on :8080")
 	fmt.Println("API Usage:")
-	fmt.Println("  GET /{path} - Retrieve data")
+	fmt.Println("  POST /_auth/register - Create a user and receive a bearer token")
+	fmt.Println("  POST /_auth/login - Exchange credentials for a bearer token")
+	fmt.Println("  GET /{path} - Retrieve data (Authorization: Bearer <token>)")
 	fmt.Println("  POST /{path} - Store data with JSON body")
 	fmt.Println("  PUT /{path} - Store raw data with Content-Type header")
 	fmt.Println("  DELETE /{path} - Delete data")
+	fmt.Println("  GET /public/{owner}/{path} - Retrieve a Public entry, no auth required")
 
-	log.Fatal(http.ListenAndServe(":8080", r))
+	log.Fatal(http.ListenAndServe(":8080", server.newRouter()))
+}
+
+// newRouter builds the route table for s. Split out from main so tests can
+// exercise the full HTTP stack without binding a real listener.
+func (s *Server) newRouter() *mux.Router {
+	r := mux.NewRouter()
+
+	// Auth endpoints
+	r.HandleFunc("/_auth/register", s.dispatch(s.handleRegister)).Methods("POST")
+	r.HandleFunc("/_auth/login", s.dispatch(s.handleLogin)).Methods("POST")
+
+	// Unauthenticated access to entries an owner has marked Public
+	r.HandleFunc("/public/{owner}/{path:.*}", s.dispatch(s.handlePublicGet)).Methods("GET")
+
+	// Content-addressed blob storage
+	r.HandleFunc("/_cas/", s.withAuth(s.dispatch(s.handleCASPut))).Methods("PUT")
+	r.HandleFunc("/_cas/{sha}", s.withAuth(s.dispatch(s.handleCASGet))).Methods("GET")
+
+	// Prefix listing and bulk cleanup
+	r.HandleFunc("/_list/{prefix:.*}", s.withAuth(s.dispatch(s.handleListGet))).Methods("GET")
+	r.HandleFunc("/_list/{prefix:.*}", s.withAuth(s.dispatch(s.handleListDelete))).Methods("DELETE")
+
+	// Version history
+	r.HandleFunc("/_history/{path:.*}", s.withAuth(s.dispatch(s.handleHistory))).Methods("GET")
+
+	// Real-time change feed
+	r.HandleFunc("/_watch/{prefix:.*}", s.withAuth(s.dispatch(s.handleWatch))).Methods("GET")
+
+	// Handle all remaining paths with wildcard, inside each user's namespace
+	r.HandleFunc("/{path:.*}", s.withAuth(s.dispatch(s.handlePath)))
+
+	return r
 }
 
 func (s *Server) initializeSampleData() {
@@ -72,183 +106,278 @@ func (s *Server) initializeSampleData() {
 	}
 
 	for key, value := range samples {
-		err := s.storeValue(key, value)
+		_, err := s.storeValue(key, value, "")
 		if err != nil {
 			log.Printf("Failed to store sample data for %s: %v", key, err)
 		}
 	}
 }
 
-func (s *Server) handlePath(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	path := vars["path"]
-
+func (s *Server) handlePath(c *Context) *HTTPError {
+	path := mux.Vars(c.R)["path"]
 	if path == "" {
-		http.Error(w, "Path is required", http.StatusBadRequest)
-		return
+		return newHTTPError(http.StatusBadRequest, "Path is required")
 	}
 
-	switch r.Method {
+	if c.User == nil {
+		return newHTTPError(http.StatusUnauthorized, "Authorization: Bearer <token> is required")
+	}
+	key := namespacedKey(c.User, path)
+
+	switch c.R.Method {
 	case "GET":
-		s.handleGet(w, r, path)
+		return s.handleGet(c, key)
 	case "POST":
-		s.handlePost(w, r, path)
+		return s.handlePost(c, key)
 	case "PUT":
-		s.handlePut(w, r, path)
+		return s.handlePut(c, key)
 	case "DELETE":
-		s.handleDelete(w, r, path)
+		return s.handleDelete(c, key)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return newHTTPError(http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
-func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, path string) {
-	value, err := s.getValue(path)
+// handlePublicGet serves an entry under another user's namespace without
+// requiring a bearer token, as long as that entry was stored with
+// Public: true.
+func (s *Server) handlePublicGet(c *Context) *HTTPError {
+	vars := mux.Vars(c.R)
+	owner := vars["owner"]
+	path := vars["path"]
+
+	value, err := s.getValue("u/" + owner + "/" + path)
 	if err != nil {
 		if err == badger.ErrKeyNotFound {
-			http.Error(w, fmt.Sprintf("Path '%s' not found", path), http.StatusNotFound)
-			return
+			return newHTTPError(http.StatusNotFound, fmt.Sprintf("Path '%s' not found", path))
 		}
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+		return wrapHTTPError(http.StatusInternalServerError, "Database error", err)
+	}
+
+	if !value.Public {
+		return newHTTPError(http.StatusNotFound, fmt.Sprintf("Path '%s' not found", path))
+	}
+
+	// Entries above the blob threshold are stored in CAS and only referenced
+	// here, so resolve the reference before writing anything out.
+	if value.BlobRef != "" {
+		data, err := s.getBlob(value.BlobRef)
+		if err != nil {
+			return wrapHTTPError(http.StatusInternalServerError, "Database error", err)
+		}
+
+		etag := `"` + value.BlobRef + `"`
+		if c.R.Header.Get("If-None-Match") == etag {
+			c.W.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+
+		c.W.Header().Set("ETag", etag)
+		c.W.Header().Set("Content-Type", value.ContentType)
+		c.W.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		c.W.Write(data)
+		return nil
+	}
+
+	c.W.Header().Set("Content-Type", value.ContentType)
+	if len(value.Data) > 0 {
+		c.W.Write(value.Data)
+		return nil
+	}
+	c.W.Write([]byte(value.Content))
+	return nil
+}
+
+// handleHistory lists every stored revision of a path, oldest first.
+func (s *Server) handleHistory(c *Context) *HTTPError {
+	path := mux.Vars(c.R)["path"]
+	if c.User == nil {
+		return newHTTPError(http.StatusUnauthorized, "Authorization: Bearer <token> is required")
+	}
+	key := namespacedKey(c.User, path)
+
+	history, err := s.listHistory(key)
+	if err != nil {
+		return wrapHTTPError(http.StatusInternalServerError, "Database error", err)
+	}
+
+	c.SendJSON(http.StatusOK, history)
+	return nil
+}
+
+func (s *Server) handleGet(c *Context, path string) *HTTPError {
+	var value *DataValue
+	var err error
+
+	if v := c.R.URL.Query().Get("version"); v != "" {
+		version, perr := strconv.ParseInt(v, 10, 64)
+		if perr != nil {
+			return newHTTPError(http.StatusBadRequest, "Invalid version")
+		}
+		value, err = s.getValueAtVersion(path, version)
+	} else {
+		value, err = s.getValue(path)
+	}
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return newHTTPError(http.StatusNotFound, fmt.Sprintf("Path '%s' not found", path))
+		}
+		return wrapHTTPError(http.StatusInternalServerError, "Database error", err)
+	}
+
+	// Entries above the blob threshold are stored in CAS and only referenced
+	// here, so resolve the reference before writing anything out.
+	if value.BlobRef != "" {
+		data, err := s.getBlob(value.BlobRef)
+		if err != nil {
+			return wrapHTTPError(http.StatusInternalServerError, "Database error", err)
+		}
+
+		etag := `"` + value.BlobRef + `"`
+		if c.R.Header.Get("If-None-Match") == etag {
+			c.W.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+
+		c.W.Header().Set("ETag", etag)
+		c.W.Header().Set("Content-Type", value.ContentType)
+		c.W.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		c.W.Write(data)
+		return nil
 	}
 
 	// Set appropriate content type
-	w.Header().Set("Content-Type", value.ContentType)
+	c.W.Header().Set("Content-Type", value.ContentType)
 
 	// For binary data, write the raw data
 	if len(value.Data) > 0 {
-		w.Write(value.Data)
-		return
+		c.W.Write(value.Data)
+		return nil
 	}
 
 	// For text content, write the content
-	w.Write([]byte(value.Content))
+	c.W.Write([]byte(value.Content))
+	return nil
 }
 
-func (s *Server) handlePost(w http.ResponseWriter, r *http.Request, path string) {
+func (s *Server) handlePost(c *Context, path string) *HTTPError {
 	var dataValue DataValue
 
 	// Parse JSON from request body
-	if err := json.NewDecoder(r.Body).Decode(&dataValue); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
+	if err := json.NewDecoder(c.R.Body).Decode(&dataValue); err != nil {
+		return newHTTPError(http.StatusBadRequest, "Invalid JSON")
 	}
 
 	// Validate required fields
 	if dataValue.ContentType == "" {
-		http.Error(w, "content_type is required", http.StatusBadRequest)
-		return
+		return newHTTPError(http.StatusBadRequest, "content_type is required")
 	}
 
-	// Store the value
-	if err := s.storeValue(path, dataValue); err != nil {
-		http.Error(w, "Failed to store data", http.StatusInternalServerError)
-		return
+	// Store the value, honoring an optimistic-concurrency precondition
+	ifMatch := strings.Trim(c.R.Header.Get("If-Match"), `"`)
+	version, err := s.storeValue(path, dataValue, ifMatch)
+	if err != nil {
+		if err == errVersionMismatch {
+			return newHTTPError(http.StatusPreconditionFailed, "Path has been modified since the given version")
+		}
+		return wrapHTTPError(http.StatusInternalServerError, "Failed to store data", err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{
+	c.SendJSON(http.StatusOK, map[string]string{
 		"status":  "success",
 		"message": fmt.Sprintf("Data stored at path: %s", path),
-	}
-	json.NewEncoder(w).Encode(response)
+		"version": strconv.FormatInt(version, 10),
+	})
+	return nil
 }
 
-func (s *Server) handlePut(w http.ResponseWriter, r *http.Request, path string) {
+func (s *Server) handlePut(c *Context, path string) *HTTPError {
 	// Read the raw body
-	body, err := io.ReadAll(r.Body)
+	body, err := io.ReadAll(c.R.Body)
 	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusBadRequest)
-		return
+		return newHTTPError(http.StatusBadRequest, "Failed to read body")
 	}
 
 	// Get content type from header
-	contentType := r.Header.Get("Content-Type")
+	contentType := c.R.Header.Get("Content-Type")
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
 
-	// Store the raw data
 	dataValue := DataValue{
-		Content:     "",
 		ContentType: contentType,
-		Data:        body,
+		Public:      c.R.URL.Query().Get("public") == "true",
+	}
+
+	// Large bodies are uploaded to CAS and only referenced here, so the
+	// record itself stays small regardless of how big the value is.
+	if len(body) > s.blobThreshold {
+		sha, err := s.putBlob(body)
+		if err != nil {
+			return wrapHTTPError(http.StatusInternalServerError, "Failed to store data", err)
+		}
+		dataValue.BlobRef = sha
+	} else {
+		dataValue.Data = body
 	}
 
-	if err := s.storeValue(path, dataValue); err != nil {
-		http.Error(w, "Failed to store data", http.StatusInternalServerError)
-		return
+	ifMatch := strings.Trim(c.R.Header.Get("If-Match"), `"`)
+	version, err := s.storeValue(path, dataValue, ifMatch)
+	if err != nil {
+		if err == errVersionMismatch {
+			return newHTTPError(http.StatusPreconditionFailed, "Path has been modified since the given version")
+		}
+		return wrapHTTPError(http.StatusInternalServerError, "Failed to store data", err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{
+	c.SendJSON(http.StatusOK, map[string]string{
 		"status":  "success",
 		"message": fmt.Sprintf("Data stored at path: %s", path),
 		"size":    fmt.Sprintf("%d bytes", len(body)),
-	}
-	json.NewEncoder(w).Encode(response)
+		"version": strconv.FormatInt(version, 10),
+	})
+	return nil
 }
 
-func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request, path string) {
+func (s *Server) handleDelete(c *Context, path string) *HTTPError {
+	// A ?version= prunes a single revision instead of the whole path
+	if v := c.R.URL.Query().Get("version"); v != "" {
+		version, perr := strconv.ParseInt(v, 10, 64)
+		if perr != nil {
+			return newHTTPError(http.StatusBadRequest, "Invalid version")
+		}
+
+		if err := s.pruneVersion(path, version); err != nil {
+			if err == badger.ErrKeyNotFound {
+				return newHTTPError(http.StatusNotFound, fmt.Sprintf("Version %d of path '%s' not found", version, path))
+			}
+			return wrapHTTPError(http.StatusInternalServerError, "Failed to delete data", err)
+		}
+
+		c.SendJSON(http.StatusOK, map[string]string{
+			"status":  "success",
+			"message": fmt.Sprintf("Version %d of path '%s' deleted", version, path),
+		})
+		return nil
+	}
+
 	// Check if the key exists first
 	_, err := s.getValue(path)
 	if err != nil {
 		if err == badger.ErrKeyNotFound {
-			http.Error(w, fmt.Sprintf("Path '%s' not found", path), http.StatusNotFound)
-			return
+			return newHTTPError(http.StatusNotFound, fmt.Sprintf("Path '%s' not found", path))
 		}
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+		return wrapHTTPError(http.StatusInternalServerError, "Database error", err)
 	}
 
 	// Delete the key
 	if err := s.deleteValue(path); err != nil {
-		http.Error(w, "Failed to delete data", http.StatusInternalServerError)
-		return
+		return wrapHTTPError(http.StatusInternalServerError, "Failed to delete data", err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{
+	c.SendJSON(http.StatusOK, map[string]string{
 		"status":  "success",
 		"message": fmt.Sprintf("Data deleted at path: %s", path),
-	}
-	json.NewEncoder(w).Encode(response)
-}
-
-func (s *Server) storeValue(key string, value DataValue) error {
-	return s.db.Update(func(txn *badger.Txn) error {
-		data, err := json.Marshal(value)
-		if err != nil {
-			return err
-		}
-		return txn.Set([]byte(key), data)
-	})
-}
-
-func (s *Server) deleteValue(key string) error {
-	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete([]byte(key))
-	})
-}
-
-func (s *Server) getValue(key string) (*DataValue, error) {
-	var value *DataValue
-	err := s.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(key))
-		if err != nil {
-			return err
-		}
-
-		return item.Value(func(val []byte) error {
-			var dataValue DataValue
-			if err := json.Unmarshal(val, &dataValue); err != nil {
-				return err
-			}
-			value = &dataValue
-			return nil
-		})
 	})
-
-	return value, err
+	return nil
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// errVersionMismatch is returned by storeValue when an If-Match precondition
+// does not match the current head version.
+var errVersionMismatch = errors.New("version mismatch")
+
+// VersionInfo describes one revision returned by /_history/{path}.
+type VersionInfo struct {
+	Version int64 `json:"version"`
+	Size    int   `json:"size"`
+}
+
+var lastVersion atomic.Int64
+
+// nextVersion returns a strictly increasing, process-wide unique version
+// number. It's seeded from wall-clock nanoseconds but never goes backwards or
+// repeats, even if two writes land in the same nanosecond.
+func nextVersion() int64 {
+	for {
+		now := time.Now().UnixNano()
+		last := lastVersion.Load()
+		next := now
+		if next <= last {
+			next = last + 1
+		}
+		if lastVersion.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+func headKey(path string) string { return "head/" + path }
+
+// versionKey zero-pads the version so that lexicographic key order (what
+// Badger's iterator gives us) matches numeric version order.
+func versionKey(path string, version int64) string {
+	return fmt.Sprintf("k/%s/%020d", path, version)
+}
+
+// storeValue appends value as a new version of key and moves the head
+// pointer to it, all within a single transaction so readers never see a torn
+// state. If ifMatch is non-empty, the write is rejected with
+// errVersionMismatch unless it equals the current head version.
+func (s *Server) storeValue(key string, value DataValue, ifMatch string) (int64, error) {
+	var version int64
+
+	err := s.db.Update(func(txn *badger.Txn) error {
+		head, err := headVersionTxn(txn, key)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		if ifMatch != "" {
+			want, err := strconv.ParseInt(ifMatch, 10, 64)
+			if err != nil || want != head {
+				return errVersionMismatch
+			}
+		}
+
+		version = nextVersion()
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if err := txn.Set([]byte(versionKey(key, version)), data); err != nil {
+			return err
+		}
+		return txn.Set([]byte(headKey(key)), []byte(strconv.FormatInt(version, 10)))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+func headVersionTxn(txn *badger.Txn, key string) (int64, error) {
+	item, err := txn.Get([]byte(headKey(key)))
+	if err != nil {
+		return 0, err
+	}
+
+	var version int64
+	err = item.Value(func(val []byte) error {
+		v, err := strconv.ParseInt(string(val), 10, 64)
+		if err != nil {
+			return err
+		}
+		version = v
+		return nil
+	})
+	return version, err
+}
+
+func (s *Server) headVersion(key string) (int64, error) {
+	var version int64
+	err := s.db.View(func(txn *badger.Txn) error {
+		v, err := headVersionTxn(txn, key)
+		version = v
+		return err
+	})
+	return version, err
+}
+
+// getValue returns the current (head) version of key.
+func (s *Server) getValue(key string) (*DataValue, error) {
+	version, err := s.headVersion(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.getValueAtVersion(key, version)
+}
+
+func (s *Server) getValueAtVersion(key string, version int64) (*DataValue, error) {
+	var value DataValue
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(versionKey(key, version)))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &value)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// getValueAtVersionSized is getValueAtVersion plus its byte size, resolved in
+// the same transaction via valueSize so a CAS-backed value's size comes from
+// Badger's item metadata instead of fetching the full blob.
+func (s *Server) getValueAtVersionSized(key string, version int64) (*DataValue, int, error) {
+	var value DataValue
+	var size int
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(versionKey(key, version)))
+		if err != nil {
+			return err
+		}
+		if err := item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &value)
+		}); err != nil {
+			return err
+		}
+		size = valueSize(txn, value)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return &value, size, nil
+}
+
+// deleteValue removes every version of key along with its head pointer.
+func (s *Server) deleteValue(key string) error {
+	return s.deleteValues([]string{key})
+}
+
+// deleteValues removes every version and head pointer of each key in keys,
+// all as a single WriteBatch, so a bulk delete across many paths costs one
+// batch flush instead of one per path.
+func (s *Server) deleteValues(keys []string) error {
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		for _, key := range keys {
+			prefix := []byte("k/" + key + "/")
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			opts.Prefix = prefix
+			it := txn.NewIterator(opts)
+
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				if err := wb.Delete(it.Item().KeyCopy(nil)); err != nil {
+					it.Close()
+					return err
+				}
+			}
+			it.Close()
+
+			if err := wb.Delete([]byte(headKey(key))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return wb.Flush()
+}
+
+// pruneVersion deletes a single revision of key and, if it was the head,
+// moves the head pointer back to the most recent remaining revision (or
+// removes it entirely if none remain).
+func (s *Server) pruneVersion(key string, version int64) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		vk := []byte(versionKey(key, version))
+		if _, err := txn.Get(vk); err != nil {
+			return err
+		}
+		if err := txn.Delete(vk); err != nil {
+			return err
+		}
+
+		newHead, found, err := latestVersion(txn, key)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return txn.Delete([]byte(headKey(key)))
+		}
+		return txn.Set([]byte(headKey(key)), []byte(strconv.FormatInt(newHead, 10)))
+	})
+}
+
+// latestVersion finds the most recent remaining version of key by seeking to
+// the end of its version range.
+func latestVersion(txn *badger.Txn, key string) (int64, bool, error) {
+	prefix := []byte("k/" + key + "/")
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	opts.Reverse = true
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	it.Seek(append(append([]byte{}, prefix...), 0xFF))
+	if !it.ValidForPrefix(prefix) {
+		return 0, false, nil
+	}
+
+	suffix := strings.TrimPrefix(string(it.Item().Key()), string(prefix))
+	version, err := strconv.ParseInt(suffix, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
+}
+
+// listHistory returns every stored revision of key, oldest first, with size
+// resolved through any CAS blob reference.
+func (s *Server) listHistory(key string) ([]VersionInfo, error) {
+	prefix := []byte("k/" + key + "/")
+	var history []VersionInfo
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			suffix := strings.TrimPrefix(string(item.Key()), string(prefix))
+			version, err := strconv.ParseInt(suffix, 10, 64)
+			if err != nil {
+				return err
+			}
+
+			var dv DataValue
+			if err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &dv)
+			}); err != nil {
+				return err
+			}
+
+			history = append(history, VersionInfo{
+				Version: version,
+				Size:    valueSize(txn, dv),
+			})
+		}
+		return nil
+	})
+
+	return history, err
+}
+
+// valueSize returns the byte size of dv's content, resolving a CAS blob
+// reference without fetching the full blob.
+func valueSize(txn *badger.Txn, dv DataValue) int {
+	if dv.BlobRef != "" {
+		if item, err := txn.Get([]byte(blobKey(dv.BlobRef))); err == nil {
+			return int(item.ValueSize())
+		}
+		return 0
+	}
+	if dv.Content != "" {
+		return len(dv.Content)
+	}
+	return len(dv.Data)
+}